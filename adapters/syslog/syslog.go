@@ -3,15 +3,21 @@ package syslog
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"log/syslog"
+	"math/rand"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"text/template"
 	"time"
@@ -25,14 +31,25 @@ const (
 	TraditionalTCPFraming TCPFraming = "traditional"
 	// OctetCountedTCPFraming prepends the size of each message before the message. https://tools.ietf.org/html/rfc6587#section-3.4.1
 	OctetCountedTCPFraming TCPFraming = "octet-counted"
+	// NonTransparentTCPFraming terminates each message with a configurable trailer byte. https://tools.ietf.org/html/rfc6587#section-3.4.2
+	NonTransparentTCPFraming TCPFraming = "non-transparent"
 
-	defaultRetryCount = 10
+	defaultRetryCount        = 10
+	defaultTrailer           = byte(0x00)
+	defaultBatchFlushMs      = 500
+	defaultMaxRetryDuration  = 5 * time.Minute
+	defaultDeadLetterMaxSize = 10 * 1024 * 1024 // 10MiB
+
+	// backoffCap bounds the exponential backoff used by retryExp before
+	// full jitter is applied.
+	backoffCap = 30 * time.Second
 )
 
 var (
 	hostname         string
 	retryCount       uint
 	tcpFraming       TCPFraming
+	tcpTrailer       byte
 	econnResetErrStr string
 )
 
@@ -107,6 +124,54 @@ func NewSyslogAdapter(route *router.Route) (router.LogAdapter, error) {
 		}
 	}
 
+	batchSize, batchFlushEvery, err := batchSettings(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	keepaliveEvery, err := keepaliveInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	maxRetryDuration, err := retryBudget()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetter, err := newDeadLetterSink()
+	if err != nil {
+		return nil, err
+	}
+
+	formatter, err := buildFormatter(format, priority, timestamp, hostname, tag, pid, structuredData, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Adapter{
+		route:            route,
+		conn:             conn,
+		formatter:        formatter,
+		transport:        transport,
+		batchSize:        batchSize,
+		batchFlushEvery:  batchFlushEvery,
+		keepaliveEvery:   keepaliveEvery,
+		maxRetryDuration: maxRetryDuration,
+		deadLetter:       deadLetter,
+		reconnGate:       make(chan struct{}, 1),
+	}, nil
+}
+
+// buildFormatter resolves SYSLOG_FORMAT to a Formatter. "rfc5424" and
+// "rfc3164" are built fresh from the resolved template strings so they keep
+// honoring the SYSLOG_PRIORITY/SYSLOG_PID/SYSLOG_TAG/etc overrides and
+// per-route options; this is the default, template-based formatter kept for
+// backward compatibility. Any other format name is looked up in the
+// formatter registry populated by RegisterFormatter. Formatters always
+// terminate a message with "\n"; frameMessage strips it back out under
+// non-transparent framing, so no formatter (built-in or third-party) needs
+// to know the configured framing mode.
+func buildFormatter(format, priority, timestamp, hostname, tag, pid, structuredData, data string) (Formatter, error) {
 	var tmplStr string
 	switch format {
 	case "rfc5424":
@@ -123,19 +188,94 @@ func NewSyslogAdapter(route *router.Route) (router.LogAdapter, error) {
 		// - the TAG field must not exceed 32 characters
 		tmplStr = fmt.Sprintf("<%s>%s %s %.32s[%s]: %s\n",
 			priority, timestamp, hostname, tag, pid, data)
+	case "rfc5424-cee":
+		return newCeeFormatter(priority, tag, pid, structuredData)
+	case "rfc5424-gelf":
+		return newGelfFormatter(priority, tag, pid, structuredData)
 	default:
-		return nil, errors.New("unsupported syslog format: " + format)
+		f, ok := lookupFormatter(format)
+		if !ok {
+			return nil, errors.New("unsupported syslog format: " + format)
+		}
+		return f, nil
 	}
 	tmpl, err := template.New("syslog").Parse(tmplStr)
 	if err != nil {
 		return nil, err
 	}
-	return &Adapter{
-		route:     route,
-		conn:      conn,
-		tmpl:      tmpl,
-		transport: transport,
-	}, nil
+	return &templateFormatter{tmpl: tmpl}, nil
+}
+
+// keepaliveInterval parses SYSLOG_KEEPALIVE_INTERVAL, a Go duration string
+// such as "30s". It is disabled (zero) by default.
+func keepaliveInterval() (time.Duration, error) {
+	s := cfg.GetEnvDefault("SYSLOG_KEEPALIVE_INTERVAL", "")
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SYSLOG_KEEPALIVE_INTERVAL value: %s", s)
+	}
+	return d, nil
+}
+
+// retryBudget parses SYSLOG_MAX_RETRY_DURATION, a Go duration string such as
+// "5m", bounding how long Stream will keep retrying a failed write before
+// giving up on it (see DeadLetterSink).
+func retryBudget() (time.Duration, error) {
+	s := cfg.GetEnvDefault("SYSLOG_MAX_RETRY_DURATION", defaultMaxRetryDuration.String())
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SYSLOG_MAX_RETRY_DURATION value: %s", s)
+	}
+	return d, nil
+}
+
+// newDeadLetterSink builds the dead-letter sink configured via
+// SYSLOG_DEADLETTER_PATH, or nil if unset, in which case a retry budget
+// timeout still ends in log.Panicf as before.
+func newDeadLetterSink() (DeadLetterSink, error) {
+	path := cfg.GetEnvDefault("SYSLOG_DEADLETTER_PATH", "")
+	if path == "" {
+		return nil, nil
+	}
+	maxSize := int64(defaultDeadLetterMaxSize)
+	if s := cfg.GetEnvDefault("SYSLOG_DEADLETTER_MAX_SIZE", ""); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid SYSLOG_DEADLETTER_MAX_SIZE value: %s", s)
+		}
+		maxSize = n
+	}
+	return &fileDeadLetterSink{path: path, maxSize: maxSize}, nil
+}
+
+// batchSettings parses the opt-in batching env vars. Batching only ever
+// applies to stream transports (TCP, TLS, Unix-stream); UDP must stay
+// one-datagram-per-message per RFC5426, so batchSize is forced to 0 there.
+func batchSettings(conn net.Conn) (int, time.Duration, error) {
+	batchSize := 0
+	if s := cfg.GetEnvDefault("SYSLOG_BATCH_SIZE", ""); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid SYSLOG_BATCH_SIZE value: %s", s)
+		}
+		batchSize = n
+	}
+	if batchSize == 0 || !isStreamConn(conn) {
+		return 0, 0, nil
+	}
+
+	flushMs := defaultBatchFlushMs
+	if s := cfg.GetEnvDefault("SYSLOG_BATCH_FLUSH_MS", ""); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid SYSLOG_BATCH_FLUSH_MS value: %s", s)
+		}
+		flushMs = n
+	}
+	return batchSize, time.Duration(flushMs) * time.Millisecond, nil
 }
 
 func setTCPFraming() error {
@@ -146,106 +286,624 @@ func setTCPFraming() error {
 	case "octet-counted":
 		tcpFraming = OctetCountedTCPFraming
 		return nil
+	case "non-transparent":
+		tcpFraming = NonTransparentTCPFraming
+		return setTCPTrailer()
 	default:
 		return fmt.Errorf("unknown SYSLOG_TCP_FRAMING value: %s", s)
 	}
 }
 
+// setTCPTrailer parses SYSLOG_TRAILER into the single byte used to terminate
+// each frame under non-transparent framing. It accepts the symbolic names
+// "LF" and "NUL", or a numeric byte such as "0x03".
+func setTCPTrailer() error {
+	switch s := cfg.GetEnvDefault("SYSLOG_TRAILER", "NUL"); s {
+	case "LF":
+		tcpTrailer = '\n'
+		return nil
+	case "NUL":
+		tcpTrailer = defaultTrailer
+		return nil
+	default:
+		n, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			return fmt.Errorf("unknown SYSLOG_TRAILER value: %s", s)
+		}
+		tcpTrailer = byte(n)
+		return nil
+	}
+}
+
+// Formatter renders a Message into the bytes written to the wire for a
+// particular syslog format.
+type Formatter interface {
+	Format(m *Message) ([]byte, error)
+}
+
+var formatterRegistry = struct {
+	sync.RWMutex
+	m map[string]Formatter
+}{m: make(map[string]Formatter)}
+
+// RegisterFormatter registers a Formatter under name, making it selectable
+// via SYSLOG_FORMAT. This lets downstream code plug in custom syslog formats
+// without forking logspout.
+func RegisterFormatter(name string, f Formatter) {
+	formatterRegistry.Lock()
+	defer formatterRegistry.Unlock()
+	formatterRegistry.m[name] = f
+}
+
+func lookupFormatter(name string) (Formatter, bool) {
+	formatterRegistry.RLock()
+	defer formatterRegistry.RUnlock()
+	f, ok := formatterRegistry.m[name]
+	return f, ok
+}
+
+// templateFormatter renders a Message through a text/template built from the
+// SYSLOG_* configuration. It backs the built-in rfc5424 and rfc3164 formats.
+type templateFormatter struct {
+	tmpl *template.Template
+}
+
+func (f *templateFormatter) Format(m *Message) ([]byte, error) {
+	return m.Render(f.tmpl)
+}
+
+// fieldTemplates holds the compiled per-field SYSLOG_* overrides (priority,
+// tag, pid) that buildFormatter resolves for every format. ceeFormatter and
+// gelfFormatter embed this instead of a single combined template, since they
+// assemble their own frame around a JSON payload rather than rendering one
+// template straight through.
+type fieldTemplates struct {
+	priority       *template.Template
+	tag            *template.Template
+	pid            *template.Template
+	structuredData string
+}
+
+func newFieldTemplates(priority, tag, pid, structuredData string) (*fieldTemplates, error) {
+	priorityTmpl, err := template.New("syslog-priority").Parse(priority)
+	if err != nil {
+		return nil, err
+	}
+	tagTmpl, err := template.New("syslog-tag").Parse(tag)
+	if err != nil {
+		return nil, err
+	}
+	pidTmpl, err := template.New("syslog-pid").Parse(pid)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldTemplates{priority: priorityTmpl, tag: tagTmpl, pid: pidTmpl, structuredData: structuredData}, nil
+}
+
+// render resolves the priority/tag/pid fields against m, honoring whatever
+// SYSLOG_PRIORITY/SYSLOG_TAG (including a route's append_tag)/SYSLOG_PID
+// overrides NewSyslogAdapter resolved them to.
+func (t *fieldTemplates) render(m *Message) (priority, tag, pid string, err error) {
+	priority, err = renderField(t.priority, m)
+	if err != nil {
+		return "", "", "", err
+	}
+	tag, err = renderField(t.tag, m)
+	if err != nil {
+		return "", "", "", err
+	}
+	pid, err = renderField(t.pid, m)
+	if err != nil {
+		return "", "", "", err
+	}
+	return priority, tag, pid, nil
+}
+
+// renderField executes tmpl against m and returns the resulting string.
+func renderField(tmpl *template.Template, m *Message) (string, error) {
+	buf, err := m.Render(tmpl)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// ceeFormatter implements "rfc5424-cee": a standard RFC5424 header whose MSG
+// is the Lumberjack/CEE "@cee:" cookie followed by a JSON document, so
+// structured container metadata survives as JSON instead of being flattened
+// into free text.
+type ceeFormatter struct {
+	*fieldTemplates
+}
+
+func newCeeFormatter(priority, tag, pid, structuredData string) (Formatter, error) {
+	fields, err := newFieldTemplates(priority, tag, pid, structuredData)
+	if err != nil {
+		return nil, err
+	}
+	return &ceeFormatter{fieldTemplates: fields}, nil
+}
+
+func (f *ceeFormatter) Format(m *Message) ([]byte, error) {
+	priority, tag, pid, err := f.render(m)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(struct {
+		Message       string `json:"message"`
+		ContainerName string `json:"container_name"`
+		Source        string `json:"source"`
+	}{
+		Message:       m.Message.Data,
+		ContainerName: m.ContainerName(),
+		Source:        m.Message.Source,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("<%s>1 %s %.255s %.48s %.128s - %s @cee: %s\n",
+		priority, m.Timestamp(), m.Hostname(), tag, pid, f.structuredData, payload)), nil
+}
+
+// gelfFormatter implements "rfc5424-gelf": a GELF 1.1 JSON document wrapped
+// inside an RFC5424 frame, for receivers that understand GELF but are fed
+// over a syslog transport.
+type gelfFormatter struct {
+	*fieldTemplates
+}
+
+func newGelfFormatter(priority, tag, pid, structuredData string) (Formatter, error) {
+	fields, err := newFieldTemplates(priority, tag, pid, structuredData)
+	if err != nil {
+		return nil, err
+	}
+	return &gelfFormatter{fieldTemplates: fields}, nil
+}
+
+func (f *gelfFormatter) Format(m *Message) ([]byte, error) {
+	priority, tag, pid, err := f.render(m)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := json.Marshal(struct {
+		Version       string  `json:"version"`
+		Host          string  `json:"host"`
+		ShortMessage  string  `json:"short_message"`
+		Timestamp     float64 `json:"timestamp"`
+		Level         int     `json:"level"`
+		ContainerName string  `json:"_container_name"`
+	}{
+		Version:       "1.1",
+		Host:          m.Hostname(),
+		ShortMessage:  m.Message.Data,
+		Timestamp:     float64(m.Message.Time.UnixNano()) / float64(time.Second),
+		Level:         int(m.Priority()) & 0x07,
+		ContainerName: m.ContainerName(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("<%s>1 %s %.255s %.48s %.128s - %s %s\n",
+		priority, m.Timestamp(), m.Hostname(), tag, pid, f.structuredData, doc)), nil
+}
+
+// DeadLetterSink stores frames that could not be delivered within the retry
+// budget, so Stream can keep processing instead of panicking, and replays
+// them once the connection recovers. Built-in implementations write to a
+// local file; a second syslog endpoint could implement the same interface.
+type DeadLetterSink interface {
+	// Write appends a single already-framed message to the sink.
+	Write(frame []byte) error
+	// Drain replays every stored frame, in order, through replay. A frame
+	// is only considered delivered, and removed from the sink, once replay
+	// returns nil for it; Drain stops at the first error so the remaining
+	// frames are preserved for the next drain attempt.
+	Drain(replay func([]byte) error) error
+}
+
+// fileDeadLetterSink is a DeadLetterSink backed by a file of length-prefixed
+// raw frames (a 4-byte big-endian length followed by that many frame bytes),
+// rotated to path+".1" once it exceeds maxSize. Frames are stored verbatim,
+// length-prefixed rather than newline-delimited, so a frame's own trailing
+// byte (which, under non-transparent TCP framing, need not be "\n" at all)
+// is never confused with a record separator. Drain always consumes path+".1"
+// before path, and rotation refuses to overwrite a path+".1" that hasn't been
+// drained yet, so a frame is never lost to rotation before it's replayed.
+type fileDeadLetterSink struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+}
+
+func (s *fileDeadLetterSink) Write(frame []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rotateIfNeeded()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeDeadLetterRecord(f, frame)
+}
+
+func writeDeadLetterRecord(w io.Writer, frame []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(frame)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func (s *fileDeadLetterSink) rotateIfNeeded() {
+	info, err := os.Stat(s.path)
+	if err != nil || info.Size() < s.maxSize {
+		return
+	}
+	if _, err := os.Stat(s.path + ".1"); err == nil {
+		// The previous rotation hasn't been drained yet; renaming over it
+		// would silently discard those frames. Keep appending to path
+		// instead and try to rotate again after the next successful drain.
+		debug("syslog: dead-letter rotation skipped, path+\".1\" not yet drained")
+		return
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		debug("syslog: dead-letter rotation failed:", err)
+	}
+}
+
+// Drain replays path+".1" (the previous rotation, if any) before path, since
+// it holds the older frames, and stops at the first file that still has
+// undelivered frames left so ordering and at-least-once delivery both hold.
+func (s *fileDeadLetterSink) Drain(replay func([]byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := drainDeadLetterFile(s.path+".1", replay); err != nil {
+		return err
+	}
+	return drainDeadLetterFile(s.path, replay)
+}
+
+// drainDeadLetterFile replays every frame stored in path, in order, through
+// replay, removing path once every frame has been delivered. It stops at the
+// first replay error and rewrites the undelivered remainder back to path, so
+// the next Drain call picks up where this one left off. A missing path is
+// not an error: not every sink has a path+".1" generation.
+func drainDeadLetterFile(path string, replay func([]byte) error) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for len(data) > 0 {
+		frame, rest, err := readDeadLetterRecord(data)
+		if err != nil {
+			debug("syslog: dead-letter file truncated, discarding remainder:", err)
+			return os.Remove(path)
+		}
+		if err := replay(frame); err != nil {
+			if werr := rewriteRemainingDeadLetterFrames(path, data); werr != nil {
+				return werr
+			}
+			return err
+		}
+		data = rest
+	}
+	return os.Remove(path)
+}
+
+// readDeadLetterRecord reads a single length-prefixed frame off the front of
+// data, returning the frame and the remaining, not-yet-consumed bytes.
+func readDeadLetterRecord(data []byte) (frame, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("dead-letter record header truncated")
+	}
+	length := binary.BigEndian.Uint32(data[:4])
+	data = data[4:]
+	if uint64(len(data)) < uint64(length) {
+		return nil, nil, fmt.Errorf("dead-letter record body truncated")
+	}
+	return data[:length], data[length:], nil
+}
+
+// rewriteRemainingDeadLetterFrames persists the frames that Drain did not
+// manage to replay (data, still in the on-disk length-prefixed format) back
+// to path, so they survive for the next drain attempt.
+func rewriteRemainingDeadLetterFrames(path string, data []byte) error {
+	return ioutil.WriteFile(path, data, 0644)
+}
+
 // Adapter streams log output to a connection in the Syslog format
 type Adapter struct {
-	conn      net.Conn
-	route     *router.Route
-	tmpl      *template.Template
-	transport router.AdapterTransport
+	connMu           sync.RWMutex
+	conn             net.Conn
+	route            *router.Route
+	formatter        Formatter
+	transport        router.AdapterTransport
+	batchSize        int
+	batchFlushEvery  time.Duration
+	pendingBatch     [][]byte // individual already-framed messages, kept separate so a retry budget timeout can dead-letter each one on its own
+	pendingBatchLen  int      // sum of len(f) for f in pendingBatch, tracked to avoid rescanning on every append
+	keepaliveEvery   time.Duration
+	writeFailed      int32 // accessed via sync/atomic; 1 once a write has failed until reconnect succeeds
+	maxRetryDuration time.Duration
+	deadLetter       DeadLetterSink
+	reconnGate       chan struct{}   // 1-buffered; held by whichever of keepalive/reconnect is currently dialing
+	done             <-chan struct{} // closed when Stream returns; set at the top of Stream
+}
+
+// getConn returns the adapter's current connection. Framing and write
+// decisions should all be made against a single snapshot from getConn so
+// that they never straddle a reconnect performed by another goroutine.
+func (a *Adapter) getConn() net.Conn {
+	a.connMu.RLock()
+	defer a.connMu.RUnlock()
+	return a.conn
+}
+
+// setConn installs a newly (re)dialed connection, closing whichever
+// connection it replaces so reconnects don't leak sockets.
+func (a *Adapter) setConn(conn net.Conn) {
+	a.connMu.Lock()
+	old := a.conn
+	a.conn = conn
+	a.connMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
 }
 
 // Stream sends log data to a connection
 func (a *Adapter) Stream(logstream chan *router.Message) {
+	done := make(chan struct{})
+	a.done = done
+	defer close(done)
+	go a.keepalive(done)
+
+	if a.batchSize > 0 {
+		a.streamBatched(logstream, done)
+		return
+	}
+
 	for message := range logstream {
 		m := &Message{message}
-		buf, err := m.Render(a.tmpl)
+		buf, err := a.formatter.Format(m)
 		if err != nil {
 			log.Println("syslog:", err)
 			return
 		}
+		conn := a.getConn()
+		buf = frameMessage(conn, buf)
 
-		if isTCPConnecion(a.conn) {
-			switch tcpFraming {
-			case OctetCountedTCPFraming:
-				buf = append([]byte(fmt.Sprintf("%d ", len(buf))), buf...)
-			case TraditionalTCPFraming:
-				// leave as-is
-			default:
-				// should never get here, validated above
-				panic("unknown framing format: " + tcpFraming)
-			}
-		}
-
-		if _, err = a.conn.Write(buf); err != nil {
+		if _, err = conn.Write(buf); err != nil {
 			log.Println("syslog:", err)
-			switch a.conn.(type) {
+			switch conn.(type) {
 			case *net.UDPConn:
 				continue
 			default:
+				atomic.StoreInt32(&a.writeFailed, 1)
 				if err = a.retry(buf, err); err != nil {
-					log.Panicf("syslog retry err: %+v", err)
-					return
+					if !a.deadLetterFrame(buf, err) {
+						return
+					}
 				}
 			}
 		}
 	}
 }
 
+// streamBatched accumulates rendered, framed messages into a.pendingBatch and
+// flushes them in a single Write once batchSize bytes have queued up or the
+// flush timer fires, whichever comes first. The flush timer also covers the
+// case where the input channel goes idle with a partial batch pending.
+func (a *Adapter) streamBatched(logstream chan *router.Message, done <-chan struct{}) {
+	flush := make(chan struct{})
+	flushDone := make(chan struct{})
+	defer close(flushDone)
+	go batchFlushTimer(a.batchFlushEvery, flush, flushDone)
+
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				a.flushBatch()
+				return
+			}
+			m := &Message{message}
+			buf, err := a.formatter.Format(m)
+			if err != nil {
+				log.Println("syslog:", err)
+				return
+			}
+			buf = frameMessage(a.getConn(), buf)
+			a.pendingBatch = append(a.pendingBatch, buf)
+			a.pendingBatchLen += len(buf)
+			if a.pendingBatchLen >= a.batchSize {
+				a.flushBatch()
+			}
+		case <-flush:
+			a.flushBatch()
+		case <-done:
+			return
+		}
+	}
+}
+
+// keepalive periodically redials the upstream connection once a write has
+// failed, so that the next incoming log message doesn't pay the reconnect
+// latency. It is a no-op unless SYSLOG_KEEPALIVE_INTERVAL is set.
+func (a *Adapter) keepalive(done <-chan struct{}) {
+	if a.keepaliveEvery <= 0 {
+		return
+	}
+	ticker := time.NewTicker(a.keepaliveEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&a.writeFailed) == 0 {
+				continue
+			}
+			select {
+			case a.reconnGate <- struct{}{}:
+			default:
+				// retry() is already dialing a new connection for this same
+				// failure; let it finish instead of racing it.
+				continue
+			}
+			conn, err := a.transport.Dial(a.route.Address, a.route.Options)
+			<-a.reconnGate
+			if err != nil {
+				debug("syslog: keepalive dial failed:", err)
+				continue
+			}
+			a.setConn(conn)
+			atomic.StoreInt32(&a.writeFailed, 0)
+			log.Println("syslog: keepalive reconnect successful")
+			go a.drainDeadLetter()
+		case <-done:
+			return
+		}
+	}
+}
+
+// batchFlushTimer periodically signals flush until done is closed, which
+// happens when streamBatched returns because logstream was closed.
+func batchFlushTimer(every time.Duration, flush chan<- struct{}, done <-chan struct{}) {
+	timer := time.NewTimer(every)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			select {
+			case flush <- struct{}{}:
+			case <-done:
+				return
+			}
+			timer.Reset(every)
+		case <-done:
+			return
+		}
+	}
+}
+
+// flushBatch writes out the pending batch as a single concatenated Write.
+// On failure it goes through the same retry/reconnect path as the unbatched
+// writer, which replays the whole un-acked batch rather than just the last
+// message. The individual frames are kept apart (rather than pre-joined)
+// purely so that, if the retry budget is exhausted, each one can be handed
+// to the DeadLetterSink as the single already-framed message its Write
+// contract expects, instead of one oversized blob. The batch is only
+// cleared once it has been written successfully or fully dead-lettered.
+func (a *Adapter) flushBatch() {
+	if len(a.pendingBatch) == 0 {
+		return
+	}
+	buf := bytes.Join(a.pendingBatch, nil)
+	if _, err := a.getConn().Write(buf); err != nil {
+		log.Println("syslog:", err)
+		atomic.StoreInt32(&a.writeFailed, 1)
+		if err = a.retry(buf, err); err != nil {
+			for _, frame := range a.pendingBatch {
+				a.deadLetterFrame(frame, err)
+			}
+		}
+	}
+	a.pendingBatch = a.pendingBatch[:0]
+	a.pendingBatchLen = 0
+}
+
+// deadLetterFrame is called once retry has exhausted the retry budget for
+// buf. If a DeadLetterSink is configured, buf is appended to it and
+// processing continues (true); otherwise the adapter panics as it always
+// has, since dropping the message silently is worse than crashing loudly.
+func (a *Adapter) deadLetterFrame(buf []byte, retryErr error) bool {
+	if a.deadLetter == nil {
+		log.Panicf("syslog retry err: %+v", retryErr)
+		return false
+	}
+	if err := a.deadLetter.Write(buf); err != nil {
+		log.Panicf("syslog retry err: %+v (dead-letter write failed: %v)", retryErr, err)
+		return false
+	}
+	log.Printf("syslog: retry budget exceeded, wrote frame to dead-letter sink: %v", retryErr)
+	return true
+}
+
+// retry attempts to recover from a failed write within the adapter's retry
+// budget (a.maxRetryDuration), first by retrying on the same connection if
+// the error looks temporary, then by reconnecting. Once the budget is spent,
+// the caller is expected to route buf to the dead-letter sink instead of
+// retrying forever.
 func (a *Adapter) retry(buf []byte, err error) error {
+	deadline := time.Now().Add(a.maxRetryDuration)
+
 	if opError, ok := err.(*net.OpError); ok {
 		if (opError.Temporary() && opError.Err.Error() != econnResetErrStr) || opError.Timeout() {
-			retryErr := a.retryTemporary(buf)
+			retryErr := a.retryTemporary(buf, deadline)
 			if retryErr == nil {
 				return nil
 			}
 		}
 	}
-	if reconnErr := a.reconnect(); reconnErr != nil {
+	if reconnErr := a.reconnect(deadline); reconnErr != nil {
 		return reconnErr
 	}
-	if _, err = a.conn.Write(buf); err != nil {
+	if _, err = a.getConn().Write(buf); err != nil {
 		log.Println("syslog: reconnect failed")
 		return err
 	}
+	atomic.StoreInt32(&a.writeFailed, 0)
 	log.Println("syslog: reconnect successful")
+	go a.drainDeadLetter()
 	return nil
 }
 
-func (a *Adapter) retryTemporary(buf []byte) error {
+func (a *Adapter) retryTemporary(buf []byte, deadline time.Time) error {
 	log.Printf("syslog: retrying tcp up to %v times\n", retryCount)
 	err := retryExp(func() error {
-		_, err := a.conn.Write(buf)
+		_, err := a.getConn().Write(buf)
 		if err == nil {
 			log.Println("syslog: retry successful")
 			return nil
 		}
 
 		return err
-	}, retryCount)
+	}, retryCount, deadline)
 
 	if err != nil {
 		log.Println("syslog: retry failed")
 		return err
 	}
 
+	atomic.StoreInt32(&a.writeFailed, 0)
 	return nil
 }
 
-func (a *Adapter) reconnect() error {
+// reconnect redials the upstream connection, holding reconnGate for the
+// duration so keepalive's background redial doesn't race it for the same
+// endpoint.
+func (a *Adapter) reconnect(deadline time.Time) error {
 	log.Printf("syslog: reconnecting up to %v times\n", retryCount)
+	a.reconnGate <- struct{}{}
+	defer func() { <-a.reconnGate }()
 	err := retryExp(func() error {
 		conn, err := a.transport.Dial(a.route.Address, a.route.Options)
 		if err != nil {
 			return err
 		}
-		a.conn = conn
+		a.setConn(conn)
 		return nil
-	}, retryCount)
+	}, retryCount, deadline)
 
 	if err != nil {
 		return err
@@ -253,7 +911,33 @@ func (a *Adapter) reconnect() error {
 	return nil
 }
 
-func retryExp(fun func() error, tries uint) error {
+// drainDeadLetter replays any frames stored in the dead-letter sink over the
+// now-healthy connection, in order. It runs in its own goroutine so a slow
+// or large drain doesn't delay Stream from processing new messages; it checks
+// a.done before every write so a drain still in progress after Stream returns
+// stops instead of writing to a torn-down adapter.
+func (a *Adapter) drainDeadLetter() {
+	if a.deadLetter == nil {
+		return
+	}
+	if err := a.deadLetter.Drain(func(frame []byte) error {
+		select {
+		case <-a.done:
+			return errors.New("syslog: stream stopped, aborting dead-letter drain")
+		default:
+		}
+		_, err := a.getConn().Write(frame)
+		return err
+	}); err != nil {
+		debug("syslog: dead-letter drain incomplete:", err)
+	}
+}
+
+// retryExp calls fun, retrying with exponential backoff and full jitter
+// (https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/)
+// until it succeeds, tries is exceeded, or deadline passes, whichever comes
+// first.
+func retryExp(fun func() error, tries uint, deadline time.Time) error {
 	try := uint(0)
 	for {
 		err := fun()
@@ -262,12 +946,26 @@ func retryExp(fun func() error, tries uint) error {
 		}
 
 		try++
-		if try > tries {
+		if try > tries || time.Now().After(deadline) {
 			return err
 		}
 
-		time.Sleep((1 << try) * 10 * time.Millisecond)
+		backoff := (1 << try) * 10 * time.Millisecond
+		if backoff > backoffCap {
+			backoff = backoffCap
+		}
+		time.Sleep(time.Duration(rand.Int63n(int64(backoff) + 1)))
+	}
+}
+
+// stripTrailer removes any instances of the configured non-transparent
+// framing trailer already present in buf, so the appended trailer remains
+// the sole frame delimiter on the wire.
+func stripTrailer(buf []byte) []byte {
+	if !bytes.Contains(buf, []byte{tcpTrailer}) {
+		return buf
 	}
+	return bytes.ReplaceAll(buf, []byte{tcpTrailer}, nil)
 }
 
 func isTCPConnecion(conn net.Conn) bool {
@@ -281,6 +979,46 @@ func isTCPConnecion(conn net.Conn) bool {
 	}
 }
 
+// isStreamConn reports whether conn is a stream transport (TCP, TLS, or a
+// stream-mode Unix socket), as opposed to a datagram transport such as UDP
+// or a "unixgram" socket. Batching only makes sense for stream transports.
+func isStreamConn(conn net.Conn) bool {
+	switch c := conn.(type) {
+	case *net.UDPConn:
+		return false
+	case *net.UnixConn:
+		return c.LocalAddr().Network() != "unixgram"
+	default:
+		return true
+	}
+}
+
+// frameMessage applies the configured TCP framing to a rendered message. It
+// is a no-op for connections other than TCP and TLS.
+func frameMessage(conn net.Conn, buf []byte) []byte {
+	if !isTCPConnecion(conn) {
+		return buf
+	}
+	switch tcpFraming {
+	case OctetCountedTCPFraming:
+		return append([]byte(fmt.Sprintf("%d ", len(buf))), buf...)
+	case NonTransparentTCPFraming:
+		// Every Formatter (built-in or registered via RegisterFormatter)
+		// terminates its output with "\n"; that's a record delimiter the
+		// formatter has no reason to know is redundant here, since the
+		// trailer below is what actually delimits the frame on the wire.
+		// Strip it so frames aren't left with a stray LF ahead of the
+		// trailer.
+		buf = bytes.TrimSuffix(buf, []byte("\n"))
+		return append(stripTrailer(buf), tcpTrailer)
+	case TraditionalTCPFraming:
+		return buf
+	default:
+		// should never get here, validated above
+		panic("unknown framing format: " + tcpFraming)
+	}
+}
+
 // Message extends router.Message for the syslog standard
 type Message struct {
 	*router.Message